@@ -0,0 +1,256 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/egtann/plugin_restaurants/provider"
+)
+
+// SessionStore persists and retrieves a user's search session and results.
+// The Postgres-backed implementation lives in store.go; tests use a fake so
+// the engine can run without a live database.
+type SessionStore interface {
+	Save(userID uint64, query, location string, offset int, businesses []provider.Business) error
+	Load(userID uint64) (*session, []provider.Business, error)
+	Sweep(ttl time.Duration) error
+}
+
+// Engine owns the restaurant-search business logic independent of Abot's
+// *dt.Msg transport. Restaurant.Run and Restaurant.FollowUp are thin
+// adapters over this, which is what makes the filter extraction and
+// keyword dispatch testable without a live Abot core or provider
+// credentials.
+type Engine struct {
+	provider provider.Provider
+	store    SessionStore
+	clock    func() time.Time
+}
+
+// NewEngine builds an Engine. provider.Provider is usually the failover
+// wrapper in providers.go so a transient outage at one backend doesn't sink
+// a search.
+func NewEngine(p provider.Provider, store SessionStore) *Engine {
+	return &Engine{provider: p, store: store, clock: time.Now}
+}
+
+// Search runs a fresh search for the given filters, persists the resulting
+// session, and returns the reply describing the result at params.Offset.
+func (e *Engine) Search(userID uint64, params provider.SearchParams, minRating float64) (string, error) {
+	businesses, err := e.provider.Search(params)
+	if err != nil {
+		// Confused response, given provider errors are rare, but
+		// unintentional runs of searches are much more common.
+		return "", nil
+	}
+	if minRating > 0 {
+		filtered := businesses[:0]
+		for _, b := range businesses {
+			if b.Rating >= minRating {
+				filtered = append(filtered, b)
+			}
+		}
+		businesses = filtered
+	}
+	if err := e.store.Save(userID, params.Term, params.Location, params.Offset, businesses); err != nil {
+		return "", err
+	}
+	return e.describe(businesses, params.Offset), nil
+}
+
+// describe builds the reply for the business at offset, given the full
+// result set from a search.
+func (e *Engine) describe(businesses []provider.Business, offset int) string {
+	if len(businesses) == 0 {
+		return "I couldn't find any places like that nearby."
+	}
+	if len(businesses) <= offset {
+		return "That's all I could find."
+	}
+	b := businesses[offset]
+	if offset == 0 {
+		return "Ok. How does this place look? " + b.Name + " at " + b.Address()
+	}
+	return fmt.Sprintf("What about %s instead?", b.Name)
+}
+
+// Next re-runs the caller's search at the next offset, i.e. "no", "not that
+// one", "something else". params is the caller's full filter set (price,
+// categories, radius, etc.) built fresh from m.State, not reloaded from the
+// persisted session, which only carries Term/Location/Offset.
+func (e *Engine) Next(userID uint64, params provider.SearchParams, minRating float64) (string, error) {
+	params.Offset++
+	return e.Search(userID, params, minRating)
+}
+
+// Narrow adjusts params in response to "cheaper" or "closer" and re-runs the
+// search from the top. params is the caller's full filter set, same as
+// Next, so narrowing a price/radius the user already set doesn't also drop
+// their cuisine or rating filter.
+func (e *Engine) Narrow(userID uint64, params provider.SearchParams, minRating float64, word string) (string, error) {
+	narrowParams(&params, word)
+	params.Offset = 0
+	return e.Search(userID, params, minRating)
+}
+
+// keywordHandlers maps each FollowUp keyword to the Engine method that
+// answers it. Keeping this as data, rather than inline in a switch, is what
+// lets HandleKeyword be table-driven tested.
+var keywordHandlers = map[string]func(e *Engine, userID uint64, offset int) (string, error){
+	"rated":       (*Engine).AskRating,
+	"rating":      (*Engine).AskRating,
+	"review":      (*Engine).AskRating,
+	"recommend":   (*Engine).AskRating,
+	"recommended": (*Engine).AskRating,
+	"number":      (*Engine).AskPhone,
+	"phone":       (*Engine).AskPhone,
+	"call":        (*Engine).AskCall,
+	"information": (*Engine).AskInfo,
+	"info":        (*Engine).AskInfo,
+	"where":       (*Engine).AskAddress,
+	"location":    (*Engine).AskAddress,
+	"address":     (*Engine).AskAddress,
+	"direction":   (*Engine).AskAddress,
+	"directions":  (*Engine).AskAddress,
+	"addr":        (*Engine).AskAddress,
+	"pictures":    (*Engine).AskPhotos,
+	"pic":         (*Engine).AskPhotos,
+	"pics":        (*Engine).AskPhotos,
+	"menu":        (*Engine).AskMenu,
+	"have":        (*Engine).AskMenu,
+	"hours":       (*Engine).AskHours,
+	"open":        (*Engine).AskHours,
+}
+
+// HandleKeyword answers a single word from the user's follow-up, returning
+// ok=false when the word isn't one this plugin recognizes.
+func (e *Engine) HandleKeyword(userID uint64, offset int, word string) (reply string, ok bool, err error) {
+	handler, found := keywordHandlers[strings.ToLower(word)]
+	if !found {
+		return "", false, nil
+	}
+	reply, err = handler(e, userID, offset)
+	return reply, true, err
+}
+
+// NoResults reports whether the user's last search came back empty, so
+// FollowUp can short-circuit to a "couldn't find anything" reply instead of
+// matching a keyword against nothing.
+func (e *Engine) NoResults(userID uint64) bool {
+	_, businesses, err := e.store.Load(userID)
+	return err == nil && len(businesses) == 0
+}
+
+func (e *Engine) business(userID uint64, offset int) (provider.Business, error) {
+	_, businesses, err := e.store.Load(userID)
+	if err != nil {
+		return provider.Business{}, err
+	}
+	if offset >= len(businesses) {
+		return provider.Business{}, ErrNoBusinesses
+	}
+	return businesses[offset], nil
+}
+
+// details asks the provider that found the business for its authoritative
+// record, used so hours/photos/menu answers aren't stale by the time the
+// user asks for them. It routes by the business's stored ProviderName
+// rather than e.provider, since a mid-session failover may have since
+// switched which provider is primary. If ProviderName isn't recognized
+// (e.g. a session persisted before a provider was renamed or removed), it
+// falls back to e.provider's own Details, which fails over across whatever
+// backends are currently registered.
+func (e *Engine) details(b provider.Business) (*provider.Business, error) {
+	if prov, ok := registry[b.ProviderName]; ok {
+		return prov.Details(b.ExternalID)
+	}
+	return e.provider.Details(b.ExternalID)
+}
+
+func (e *Engine) AskRating(userID uint64, offset int) (string, error) {
+	b, err := e.business(userID, offset)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("It has a %.1f star review on Yelp", b.Rating), nil
+}
+
+func (e *Engine) AskPhone(userID uint64, offset int) (string, error) {
+	b, err := e.business(userID, offset)
+	if err != nil {
+		return "", err
+	}
+	return b.Phone, nil
+}
+
+func (e *Engine) AskCall(userID uint64, offset int) (string, error) {
+	b, err := e.business(userID, offset)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("You can reach them here: %s", b.Phone), nil
+}
+
+func (e *Engine) AskInfo(userID uint64, offset int) (string, error) {
+	b, err := e.business(userID, offset)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("Here's some more info: %s", b.URL), nil
+}
+
+func (e *Engine) AskAddress(userID uint64, offset int) (string, error) {
+	b, err := e.business(userID, offset)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("It's at %s", b.Address()), nil
+}
+
+func (e *Engine) AskPhotos(userID uint64, offset int) (string, error) {
+	b, err := e.business(userID, offset)
+	if err != nil {
+		return "", err
+	}
+	d, err := e.details(b)
+	if err != nil || len(d.Photos) == 0 {
+		url := b.URL
+		if d != nil {
+			url = d.URL
+		}
+		return fmt.Sprintf("I found some pics here: %s", url), nil
+	}
+	return fmt.Sprintf("I found some pics here: %s", d.Photos[0]), nil
+}
+
+func (e *Engine) AskMenu(userID uint64, offset int) (string, error) {
+	b, err := e.business(userID, offset)
+	if err != nil {
+		return "", err
+	}
+	d, err := e.details(b)
+	if err != nil || len(d.MenuURL) == 0 {
+		url := b.URL
+		if d != nil {
+			url = d.URL
+		}
+		return fmt.Sprintf("Here's what I found... %s", url), nil
+	}
+	return fmt.Sprintf("Here's what I found... %s", d.MenuURL), nil
+}
+
+func (e *Engine) AskHours(userID uint64, offset int) (string, error) {
+	b, err := e.business(userID, offset)
+	if err != nil {
+		return "", err
+	}
+	d, err := e.details(b)
+	if err != nil {
+		return "I'm not sure what their hours are.", nil
+	}
+	if d.IsOpenNow {
+		return "They're open right now.", nil
+	}
+	return "They're closed right now.", nil
+}
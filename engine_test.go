@@ -0,0 +1,196 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/egtann/plugin_restaurants/provider"
+)
+
+// fakeProvider is a provider.Provider that returns canned data, so Engine
+// tests don't need real Yelp/Google Places credentials.
+type fakeProvider struct {
+	name       string
+	businesses []provider.Business
+	detail     *provider.Business
+	searchErr  error
+	lastParams provider.SearchParams
+}
+
+func (f *fakeProvider) Name() string { return f.name }
+
+func (f *fakeProvider) Search(p provider.SearchParams) ([]provider.Business, error) {
+	f.lastParams = p
+	if f.searchErr != nil {
+		return nil, f.searchErr
+	}
+	return f.businesses, nil
+}
+
+func (f *fakeProvider) Details(externalID string) (*provider.Business, error) {
+	if f.detail == nil {
+		return nil, provider.ErrNotSupported
+	}
+	return f.detail, nil
+}
+
+func (f *fakeProvider) AutocompleteLocation(input string, bias *provider.Coordinates) (string, error) {
+	return input, nil
+}
+
+// fakeStore is an in-memory SessionStore, so Engine tests don't need a
+// Postgres connection.
+type fakeStore struct {
+	sessions   map[uint64]*session
+	businesses map[uint64][]provider.Business
+}
+
+func newFakeStore() *fakeStore {
+	return &fakeStore{
+		sessions:   make(map[uint64]*session),
+		businesses: make(map[uint64][]provider.Business),
+	}
+}
+
+func (f *fakeStore) Save(userID uint64, query, location string, offset int, businesses []provider.Business) error {
+	f.sessions[userID] = &session{UserID: userID, Query: query, Location: location, Offset: offset}
+	f.businesses[userID] = businesses
+	return nil
+}
+
+func (f *fakeStore) Load(userID uint64) (*session, []provider.Business, error) {
+	s, ok := f.sessions[userID]
+	if !ok {
+		return nil, nil, ErrNoBusinesses
+	}
+	return s, f.businesses[userID], nil
+}
+
+func (f *fakeStore) Sweep(ttl time.Duration) error { return nil }
+
+func testEngine(businesses []provider.Business) (*Engine, *fakeStore, *fakeProvider) {
+	prov := &fakeProvider{name: "yelp", businesses: businesses}
+	registry = map[string]provider.Provider{"yelp": prov}
+	store := newFakeStore()
+	e := NewEngine(prov, store)
+	return e, store, prov
+}
+
+func TestEngineHandleKeyword(t *testing.T) {
+	businesses := []provider.Business{{
+		ProviderName:   "yelp",
+		ExternalID:     "abc",
+		Name:           "Pizza Place",
+		Rating:         4.5,
+		Phone:          "+15551234567",
+		URL:            "https://yelp.com/biz/abc",
+		DisplayAddress: []string{"123 Main St", "San Francisco, CA"},
+	}}
+	e, store, _ := testEngine(businesses)
+	if err := store.Save(1, "pizza", "san francisco", 0, businesses); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		word     string
+		wantOK   bool
+		wantText string
+	}{
+		{"rating", true, "It has a 4.5 star review on Yelp"},
+		{"phone", true, "+15551234567"},
+		{"call", true, "You can reach them here: +15551234567"},
+		{"address", true, "It's at 123 Main St in San Francisco, CA"},
+		{"info", true, "Here's some more info: https://yelp.com/biz/abc"},
+		{"banana", false, ""},
+	}
+	for _, c := range cases {
+		reply, ok, err := e.HandleKeyword(1, 0, c.word)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", c.word, err)
+		}
+		if ok != c.wantOK {
+			t.Fatalf("%s: got ok=%v, want %v", c.word, ok, c.wantOK)
+		}
+		if ok && reply != c.wantText {
+			t.Fatalf("%s: got reply %q, want %q", c.word, reply, c.wantText)
+		}
+	}
+}
+
+// TestEngineSearchMinRatingFiltersFullPage guards against min_rating being
+// applied to whatever single candidate the provider happened to return for
+// the given offset, rather than to a full page of candidates. A provider
+// that only fetched offset+1 results would see just the low-rated business
+// below and report no matches, even though a better-rated one exists later
+// in the page.
+func TestEngineSearchMinRatingFiltersFullPage(t *testing.T) {
+	businesses := []provider.Business{
+		{Name: "Meh Thai", Rating: 3.0},
+		{Name: "Great Thai", Rating: 4.5},
+		{Name: "Also Meh Thai", Rating: 3.5},
+	}
+	e, _, _ := testEngine(businesses)
+	params := provider.SearchParams{Term: "thai", Location: "sf", Offset: 0}
+	reply, err := e.Search(1, params, 4.0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "Ok. How does this place look? Great Thai at "
+	if reply != want {
+		t.Fatalf("got reply %q, want %q", reply, want)
+	}
+}
+
+func TestEngineNoResults(t *testing.T) {
+	e, store, _ := testEngine(nil)
+	if err := store.Save(1, "pizza", "san francisco", 0, nil); err != nil {
+		t.Fatal(err)
+	}
+	if !e.NoResults(1) {
+		t.Fatal("expected NoResults to be true for an empty search")
+	}
+}
+
+// TestEngineNarrowCheaper guards against Narrow advancing the offset
+// instead of restarting the search from the top: with a single business
+// and a stale offset of 2, a buggy Narrow would look past the end of the
+// result set and reply "That's all I could find."
+func TestEngineNarrowCheaper(t *testing.T) {
+	e, _, prov := testEngine([]provider.Business{{Name: "Cheap Eats"}})
+	params := provider.SearchParams{Term: "thai", Location: "sf", Offset: 2, Price: "3,4"}
+	reply, err := e.Narrow(1, params, 0, "cheaper")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "Ok. How does this place look? Cheap Eats at "
+	if reply != want {
+		t.Fatalf("got reply %q, want %q", reply, want)
+	}
+	if prov.lastParams.Offset != 0 {
+		t.Fatalf("got offset %d, want 0", prov.lastParams.Offset)
+	}
+	if prov.lastParams.Price != "1,2" {
+		t.Fatalf("got price %q, want %q", prov.lastParams.Price, "1,2")
+	}
+	if prov.lastParams.Term != "thai" {
+		t.Fatalf("narrowing dropped the cuisine filter: got term %q, want %q", prov.lastParams.Term, "thai")
+	}
+}
+
+// TestEngineNextPreservesFilters guards against "something else" dropping
+// the price/categories/etc. filters extractFilters put on the original
+// query, since Next only has session.Offset to go on if it reloads from
+// the store instead of taking the caller's params.
+func TestEngineNextPreservesFilters(t *testing.T) {
+	e, _, prov := testEngine([]provider.Business{{Name: "Thai Place"}, {Name: "Thai Place 2"}})
+	params := provider.SearchParams{Term: "thai", Location: "sf", Offset: 0, Price: "1", Radius: 500}
+	if _, err := e.Next(1, params, 0); err != nil {
+		t.Fatal(err)
+	}
+	if prov.lastParams.Offset != 1 {
+		t.Fatalf("got offset %d, want 1", prov.lastParams.Offset)
+	}
+	if prov.lastParams.Price != "1" || prov.lastParams.Radius != 500 {
+		t.Fatalf("Next dropped filters: got %+v", prov.lastParams)
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	dt "github.com/itsabot/abot/shared/datatypes"
+
+	"github.com/egtann/plugin_restaurants/provider"
+)
+
+// metersPerMile converts the miles in phrases like "within 2 miles" into the
+// meters Yelp's radius filter expects.
+const metersPerMile = 1609
+
+// defaultRadius is used for vague distance phrases ("nearby", "close by")
+// that don't name a specific distance, and as the starting point when
+// "closer" narrows a search that had no radius; "walking distance" uses
+// walkingRadius instead.
+const defaultRadius = 1600 // ~1 mile
+const walkingRadius = 800  // ~0.5 miles, a comfortable walk
+
+// cuisineAliases maps common cuisine words to the category aliases Yelp's
+// Fusion API recognizes. This is intentionally small; extend it as new
+// cuisines come up rather than trying to enumerate Yelp's full category
+// tree up front.
+var cuisineAliases = map[string]string{
+	"thai":       "thai",
+	"sushi":      "sushi",
+	"japanese":   "japanese",
+	"chinese":    "chinese",
+	"mexican":    "mexican",
+	"italian":    "italian",
+	"indian":     "indpak",
+	"vegan":      "vegan",
+	"vegetarian": "vegetarian",
+	"pizza":      "pizza",
+	"bbq":        "bbq",
+	"seafood":    "seafood",
+	"french":     "french",
+	"korean":     "korean",
+	"vietnamese": "vietnamese",
+	"greek":      "greek",
+	"burger":     "burgers",
+	"burgers":    "burgers",
+	"breakfast":  "breakfast_brunch",
+	"brunch":     "breakfast_brunch",
+	"bar":        "bars",
+	"bars":       "bars",
+	"coffee":     "coffee",
+}
+
+var milesRE = regexp.MustCompile(`(?i)within\s+(\d+(?:\.\d+)?)\s*miles?`)
+var ratingRE = regexp.MustCompile(`(?i)(\d(?:\.\d)?)\s*(?:\+|stars?|star)\s*(?:or better|or more|and up)?`)
+
+// extractFilters scans the structured input and raw sentence for modifiers
+// like price, cuisine, distance, rating, and "open now" and materializes
+// them into m.State so Restaurant.Run can plumb them through to
+// searchParamsFromMsg. It's only called from Run, on the initial query; a
+// later "cheaper" or "closer" narrows the filters extractFilters already
+// put in m.State via narrowParams instead of re-running extraction. It
+// returns the words that weren't consumed as filters, which the caller
+// uses to build the free-text search term.
+func extractFilters(m *dt.Msg) []string {
+	words := m.StructuredInput.Objects
+	sentence := strings.ToLower(m.Sentence)
+
+	var remaining []string
+	for _, w := range words {
+		switch strings.ToLower(w) {
+		case "$", "cheap", "affordable", "inexpensive":
+			m.State["price"] = "1"
+		case "$$":
+			m.State["price"] = "1,2"
+		case "$$$", "fancy", "upscale", "expensive":
+			m.State["price"] = "3,4"
+		case "$$$$":
+			m.State["price"] = "4"
+		default:
+			if alias, ok := cuisineAliases[strings.ToLower(w)]; ok {
+				m.State["categories"] = alias
+			} else {
+				remaining = append(remaining, w)
+			}
+		}
+	}
+
+	switch {
+	case strings.Contains(sentence, "walking distance"):
+		m.State["radius"] = float64(walkingRadius)
+	case strings.Contains(sentence, "nearby"), strings.Contains(sentence, "close by"):
+		m.State["radius"] = float64(defaultRadius)
+	default:
+		if match := milesRE.FindStringSubmatch(sentence); len(match) == 2 {
+			if miles, err := strconv.ParseFloat(match[1], 64); err == nil {
+				m.State["radius"] = float64(int(miles * metersPerMile))
+			}
+		}
+	}
+
+	switch {
+	case strings.Contains(sentence, "open late"), strings.Contains(sentence, "still open"),
+		strings.Contains(sentence, "open now"):
+		m.State["open_now"] = true
+	}
+
+	if strings.Contains(sentence, "highly rated") || strings.Contains(sentence, "best rated") {
+		m.State["min_rating"] = 4.0
+	} else if match := ratingRE.FindStringSubmatch(sentence); len(match) == 2 {
+		if rating, err := strconv.ParseFloat(match[1], 64); err == nil {
+			m.State["min_rating"] = rating
+		}
+	}
+
+	return remaining
+}
+
+// narrowParams adjusts search filters in response to a follow-up like
+// "cheaper" or "closer".
+func narrowParams(params *provider.SearchParams, word string) {
+	switch strings.ToLower(word) {
+	case "cheaper":
+		switch params.Price {
+		case "", "4", "3,4":
+			params.Price = "1,2"
+		case "1,2":
+			params.Price = "1"
+		}
+	case "closer":
+		if params.Radius == 0 {
+			params.Radius = defaultRadius
+		}
+		params.Radius /= 2
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"testing"
+
+	dt "github.com/itsabot/abot/shared/datatypes"
+	"github.com/itsabot/abot/shared/nlp"
+)
+
+func TestExtractFilters(t *testing.T) {
+	cases := []struct {
+		name     string
+		sentence string
+		objects  []string
+		want     map[string]interface{}
+	}{
+		{
+			name:     "cheap",
+			sentence: "find a cheap thai place",
+			objects:  []string{"cheap", "thai", "place"},
+			want:     map[string]interface{}{"price": "1", "categories": "thai"},
+		},
+		{
+			name:     "fancy",
+			sentence: "find somewhere fancy",
+			objects:  []string{"fancy"},
+			want:     map[string]interface{}{"price": "3,4"},
+		},
+		{
+			name:     "within miles",
+			sentence: "find sushi within 2 miles",
+			objects:  []string{"sushi"},
+			want:     map[string]interface{}{"categories": "sushi", "radius": float64(2 * metersPerMile)},
+		},
+		{
+			name:     "walking distance",
+			sentence: "something within walking distance",
+			objects:  []string{},
+			want:     map[string]interface{}{"radius": float64(walkingRadius)},
+		},
+		{
+			name:     "open now",
+			sentence: "find tacos that are open now",
+			objects:  []string{"tacos"},
+			want:     map[string]interface{}{"open_now": true},
+		},
+		{
+			name:     "highly rated",
+			sentence: "find a highly rated burger joint",
+			objects:  []string{"burger"},
+			want:     map[string]interface{}{"categories": "burgers", "min_rating": 4.0},
+		},
+		{
+			name:     "4 stars or better",
+			sentence: "find pizza 4 stars or better",
+			objects:  []string{"pizza"},
+			want:     map[string]interface{}{"categories": "pizza", "min_rating": 4.0},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			m := &dt.Msg{
+				Sentence: c.sentence,
+				State:    map[string]interface{}{},
+				StructuredInput: &nlp.StructuredInput{
+					Objects: c.objects,
+				},
+			}
+			extractFilters(m)
+			for k, want := range c.want {
+				got := m.State[k]
+				if got != want {
+					t.Errorf("State[%q] = %v, want %v", k, got, want)
+				}
+			}
+		})
+	}
+}
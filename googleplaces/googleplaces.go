@@ -0,0 +1,244 @@
+// Package googleplaces implements provider.Provider against the Google
+// Places Text Search, Place Details, and Autocomplete APIs.
+package googleplaces
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/egtann/plugin_restaurants/provider"
+)
+
+const (
+	textSearchURL   = "https://maps.googleapis.com/maps/api/place/textsearch/json"
+	detailsURL      = "https://maps.googleapis.com/maps/api/place/details/json"
+	autocompleteURL = "https://maps.googleapis.com/maps/api/place/autocomplete/json"
+)
+
+// Provider talks to the Google Places API using a server-side API key.
+type Provider struct {
+	apiKey string
+	http   *http.Client
+}
+
+// New builds a Google Places provider using the GOOGLE_PLACES_API_KEY
+// environment variable.
+func New() *Provider {
+	return &Provider{
+		apiKey: os.Getenv("GOOGLE_PLACES_API_KEY"),
+		http:   &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (p *Provider) Name() string { return "googleplaces" }
+
+type textSearchResp struct {
+	Status  string `json:"status"`
+	Results []struct {
+		PlaceID          string   `json:"place_id"`
+		Name             string   `json:"name"`
+		FormattedAddress string   `json:"formatted_address"`
+		Rating           float64  `json:"rating"`
+		UserRatingsTotal int      `json:"user_ratings_total"`
+		PriceLevel       int      `json:"price_level"`
+		Types            []string `json:"types"`
+		OpeningHours     struct {
+			OpenNow bool `json:"open_now"`
+		} `json:"opening_hours"`
+		Geometry struct {
+			Location struct {
+				Lat float64 `json:"lat"`
+				Lng float64 `json:"lng"`
+			} `json:"location"`
+		} `json:"geometry"`
+		Photos []struct {
+			PhotoReference string `json:"photo_reference"`
+		} `json:"photos"`
+		PermanentlyClosed bool `json:"permanently_closed"`
+	} `json:"results"`
+}
+
+type detailsResp struct {
+	Status string `json:"status"`
+	Result struct {
+		Website        string `json:"website"`
+		FormattedPhone string `json:"formatted_phone_number"`
+		URL            string `json:"url"`
+		OpeningHours   struct {
+			OpenNow bool `json:"open_now"`
+		} `json:"opening_hours"`
+	} `json:"result"`
+}
+
+type autocompleteResp struct {
+	Status      string `json:"status"`
+	Predictions []struct {
+		Description string `json:"description"`
+		PlaceID     string `json:"place_id"`
+	} `json:"predictions"`
+}
+
+func (p *Provider) get(rawURL string, params url.Values, v interface{}) error {
+	params.Set("key", p.apiKey)
+	resp, err := p.http.Get(rawURL + "?" + params.Encode())
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("google places status %d", resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// Search queries the Places Text Search endpoint. Places' filters don't map
+// 1:1 onto Yelp's, so category is folded into the free-text query, price
+// becomes a minprice/maxprice range, and radius/open_now are passed through
+// as-is.
+func (p *Provider) Search(sp provider.SearchParams) ([]provider.Business, error) {
+	term := sp.Term
+	if sp.Categories != "" {
+		term = sp.Categories + " " + term
+	}
+	params := url.Values{
+		"query": {term + " restaurants in " + sp.Location},
+	}
+	if sp.Radius > 0 {
+		params.Set("radius", strconv.Itoa(sp.Radius))
+	}
+	if sp.OpenNow {
+		params.Set("opennow", "true")
+	}
+	if sp.Price != "" {
+		if lo, hi, ok := priceLevelRange(sp.Price); ok {
+			params.Set("minprice", strconv.Itoa(lo))
+			params.Set("maxprice", strconv.Itoa(hi))
+		}
+	}
+	var data textSearchResp
+	if err := p.get(textSearchURL, params, &data); err != nil {
+		return nil, err
+	}
+	if data.Status != "OK" && data.Status != "ZERO_RESULTS" {
+		return nil, fmt.Errorf("google places status %s", data.Status)
+	}
+	// Text Search already caps a single response to one page (up to 20
+	// results); return all of it rather than truncating to offset+1, so
+	// Engine has candidates to filter by min_rating beyond just the
+	// business at offset.
+	businesses := make([]provider.Business, len(data.Results))
+	for i, r := range data.Results {
+		var photos []string
+		if len(r.Photos) > 0 {
+			photos = []string{photoURL(r.Photos[0].PhotoReference, p.apiKey)}
+		}
+		businesses[i] = provider.Business{
+			ProviderName: "googleplaces",
+			ExternalID:   r.PlaceID,
+			Name:         r.Name,
+			Rating:       r.Rating,
+			ReviewCount:  r.UserRatingsTotal,
+			Price:        priceString(r.PriceLevel),
+			Categories:   r.Types,
+			IsClosed:     r.PermanentlyClosed,
+			IsOpenNow:    r.OpeningHours.OpenNow,
+			Photos:       photos,
+			Coordinates: provider.Coordinates{
+				Latitude:  r.Geometry.Location.Lat,
+				Longitude: r.Geometry.Location.Lng,
+			},
+			DisplayAddress: []string{r.FormattedAddress},
+		}
+	}
+	return businesses, nil
+}
+
+// Details fetches the Place Details record for a place ID, filling in the
+// phone number, website, and menu/info URL that Text Search omits.
+func (p *Provider) Details(externalID string) (*provider.Business, error) {
+	params := url.Values{
+		"place_id": {externalID},
+		"fields":   {"formatted_phone_number,website,url,opening_hours"},
+	}
+	var data detailsResp
+	if err := p.get(detailsURL, params, &data); err != nil {
+		return nil, err
+	}
+	if data.Status != "OK" {
+		return nil, fmt.Errorf("google places status %s", data.Status)
+	}
+	return &provider.Business{
+		ProviderName: "googleplaces",
+		ExternalID:   externalID,
+		Phone:        data.Result.FormattedPhone,
+		URL:          data.Result.Website,
+		MenuURL:      data.Result.Website,
+		IsOpenNow:    data.Result.OpeningHours.OpenNow,
+	}, nil
+}
+
+// AutocompleteLocation resolves ambiguous user-typed location text against
+// a bias circle around the user's known location, e.g. turning "the
+// airport" into a specific, geocodable place.
+func (p *Provider) AutocompleteLocation(input string, bias *provider.Coordinates) (string, error) {
+	params := url.Values{"input": {input}, "types": {"geocode"}}
+	if bias != nil {
+		params.Set("location", fmt.Sprintf("%f,%f", bias.Latitude, bias.Longitude))
+		params.Set("radius", "50000")
+	}
+	var data autocompleteResp
+	if err := p.get(autocompleteURL, params, &data); err != nil {
+		return "", err
+	}
+	if data.Status != "OK" || len(data.Predictions) == 0 {
+		return input, nil
+	}
+	return data.Predictions[0].Description, nil
+}
+
+func photoURL(ref, apiKey string) string {
+	return fmt.Sprintf(
+		"https://maps.googleapis.com/maps/api/place/photo?maxwidth=800&photoreference=%s&key=%s",
+		ref, apiKey)
+}
+
+// priceString converts Places' 0-4 price_level into Yelp-style "$"-"$$$$"
+// so callers don't need to know which provider answered.
+func priceString(level int) string {
+	if level <= 0 || level > 4 {
+		return ""
+	}
+	return strings.Repeat("$", level)
+}
+
+// priceLevelRange takes the lowest and highest tiers out of a Yelp-style
+// comma-separated price filter (e.g. "1,2" or "3,4"), since Places bounds a
+// search with separate minprice/maxprice rather than an enumerated set.
+// Using only maxprice would let a filter like "3,4" (fancy) through
+// unfiltered, since 4 is the ceiling of the whole scale.
+func priceLevelRange(price string) (lo, hi int, found bool) {
+	start := 0
+	for i := 0; i <= len(price); i++ {
+		if i == len(price) || price[i] == ',' {
+			if i > start {
+				if n, err := strconv.Atoi(price[start:i]); err == nil {
+					if !found || n < lo {
+						lo = n
+					}
+					if n > hi {
+						hi = n
+					}
+					found = true
+				}
+			}
+			start = i + 1
+		}
+	}
+	return lo, hi, found
+}
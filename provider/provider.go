@@ -0,0 +1,88 @@
+// Package provider defines the interface restaurant-search backends
+// implement, and the normalized types they exchange with the plugin. This
+// lets the plugin swap or fail over between Yelp, Google Places, or any
+// future backend without the rest of the code caring which one answered a
+// given query.
+package provider
+
+import "errors"
+
+// ErrNotSupported is returned by a Provider method that a given backend
+// doesn't implement, e.g. Yelp has no location-autocomplete endpoint.
+var ErrNotSupported = errors.New("provider: not supported")
+
+// Coordinates is a latitude/longitude pair, used both for a business's
+// location and as a bias point for autocomplete.
+type Coordinates struct {
+	Latitude  float64
+	Longitude float64
+}
+
+// Business is a normalized restaurant record, independent of which backend
+// produced it. ProviderName and ExternalID together are enough to route a
+// follow-up Details call back to the backend that returned the business.
+type Business struct {
+	ProviderName   string
+	ExternalID     string
+	Name           string
+	ImageURL       string
+	URL            string
+	MenuURL        string
+	Phone          string
+	Distance       float64
+	Rating         float64
+	Price          string
+	ReviewCount    int
+	IsClosed       bool
+	IsOpenNow      bool
+	Categories     []string
+	Photos         []string
+	Coordinates    Coordinates
+	City           string
+	DisplayAddress []string
+}
+
+// Address formats the business's street address for display, folding in
+// the city when Yelp/Google split them across separate fields.
+func (b Business) Address() string {
+	if len(b.DisplayAddress) == 0 {
+		return ""
+	}
+	if len(b.DisplayAddress) > 1 {
+		return b.DisplayAddress[0] + " in " + b.DisplayAddress[1]
+	}
+	return b.DisplayAddress[0]
+}
+
+// SearchParams holds the filters a search can be narrowed by. Not every
+// backend supports every field; a Provider should ignore filters it can't
+// express rather than erroring.
+type SearchParams struct {
+	Term       string
+	Location   string
+	Offset     int
+	Price      string // comma-separated 1-4, e.g. "1,2"
+	Categories string // comma-separated category aliases
+	Radius     int    // meters
+	OpenNow    bool
+	OpenAt     int64
+	SortBy     string // best_match, rating, review_count, distance
+}
+
+// Provider is implemented by each restaurant-search backend. Search results
+// must be returned in a stable order since FollowUp indexes into them by
+// offset.
+type Provider interface {
+	// Name identifies the provider, e.g. "yelp", stored on each Business
+	// it returns so a later Details call can be routed correctly.
+	Name() string
+
+	Search(p SearchParams) ([]Business, error)
+	Details(externalID string) (*Business, error)
+
+	// AutocompleteLocation resolves ambiguous user-typed location text
+	// against an optional bias point, returning a normalized location
+	// string suitable for Search. Providers without an autocomplete
+	// endpoint should return ErrNotSupported.
+	AutocompleteLocation(input string, bias *Coordinates) (string, error)
+}
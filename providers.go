@@ -0,0 +1,83 @@
+package main
+
+import (
+	"os"
+
+	"github.com/egtann/plugin_restaurants/googleplaces"
+	"github.com/egtann/plugin_restaurants/provider"
+	"github.com/egtann/plugin_restaurants/yelp"
+)
+
+// primary is the provider selected by RESTAURANT_PROVIDER, used for search.
+// On a search error it fails over to the other registered provider rather
+// than returning the confused silent failure searchYelp used to.
+var primary *failoverProvider
+
+// registry looks up a provider by name so a Business's stored
+// ProviderName routes Details calls back to the backend that found it,
+// even after failover switched the active search provider.
+var registry map[string]provider.Provider
+
+// initProviders wires up the Yelp and Google Places backends and selects
+// which one searches first based on RESTAURANT_PROVIDER (defaults to yelp).
+func initProviders() {
+	y := yelp.New()
+	g := googleplaces.New()
+	registry = map[string]provider.Provider{
+		y.Name(): y,
+		g.Name(): g,
+	}
+	order := []provider.Provider{y, g}
+	if os.Getenv("RESTAURANT_PROVIDER") == g.Name() {
+		order = []provider.Provider{g, y}
+	}
+	primary = &failoverProvider{providers: order}
+}
+
+// failoverProvider tries each provider in order, falling back to the next
+// on error so a transient outage at one backend doesn't sink the search.
+type failoverProvider struct {
+	providers []provider.Provider
+}
+
+func (f *failoverProvider) Name() string { return f.providers[0].Name() }
+
+func (f *failoverProvider) Search(p provider.SearchParams) ([]provider.Business, error) {
+	var lastErr error
+	for _, prov := range f.providers {
+		businesses, err := prov.Search(p)
+		if err == nil {
+			return businesses, nil
+		}
+		l.Error(err)
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+// Details receives only an external ID, not the Business it came from, so
+// there's no ProviderName to route by here; Engine.details uses the
+// registry directly for that and only falls back to this. Details and
+// AutocompleteLocation just try providers in the same failover order as
+// Search.
+func (f *failoverProvider) Details(externalID string) (*provider.Business, error) {
+	var lastErr error
+	for _, prov := range f.providers {
+		b, err := prov.Details(externalID)
+		if err == nil {
+			return b, nil
+		}
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+func (f *failoverProvider) AutocompleteLocation(input string, bias *provider.Coordinates) (string, error) {
+	for _, prov := range f.providers {
+		resolved, err := prov.AutocompleteLocation(input, bias)
+		if err == nil {
+			return resolved, nil
+		}
+	}
+	return input, nil
+}
@@ -1,15 +1,11 @@
 package main
 
 import (
-	"encoding/json"
 	"errors"
 	"flag"
-	"fmt"
-	"net/url"
-	"os"
 	"strings"
+	"time"
 
-	"github.com/garyburd/go-oauth/oauth"
 	"github.com/itsabot/abot/shared/datatypes"
 	"github.com/itsabot/abot/shared/knowledge"
 	"github.com/itsabot/abot/shared/language"
@@ -17,36 +13,18 @@ import (
 	"github.com/itsabot/abot/shared/nlp"
 	"github.com/itsabot/abot/shared/plugin"
 	"github.com/jmoiron/sqlx"
+
+	"github.com/egtann/plugin_restaurants/provider"
 )
 
 type Restaurant string
 
-type client struct {
-	client oauth.Client
-	token  oauth.Credentials
-}
-
-type yelpResp struct {
-	Businesses []struct {
-		Name         string
-		ImageURL     string `json:"image_url"`
-		MobileURL    string `json:"mobile_url"`
-		DisplayPhone string `json:"display_phone"`
-		Distance     int
-		Rating       float64
-		Location     struct {
-			City           string
-			DisplayAddress []string `json:"display_address"`
-		}
-	}
-}
-
 var ErrNoBusinesses = errors.New("no businesses")
 
-var c client
 var db *sqlx.DB
 var p *plugin.Plugin
 var l *log.Logger
+var engine *Engine
 
 const pluginName string = "restaurant"
 
@@ -56,15 +34,24 @@ func main() {
 		"Port used to communicate with Abot.")
 	flag.Parse()
 	l = log.New(pluginName)
-	c.client.Credentials.Token = os.Getenv("YELP_CONSUMER_KEY")
-	c.client.Credentials.Secret = os.Getenv("YELP_CONSUMER_SECRET")
-	c.token.Token = os.Getenv("YELP_TOKEN")
-	c.token.Secret = os.Getenv("YELP_TOKEN_SECRET")
+	initProviders()
 	var err error
 	db, err = plugin.ConnectDB()
 	if err != nil {
 		l.Fatal(err)
 	}
+	if err := migrate(); err != nil {
+		l.Fatal(err)
+	}
+	engine = NewEngine(primary, newPgStore(db))
+	go func() {
+		t := time.NewTicker(time.Hour)
+		for range t.C {
+			if err := engine.store.Sweep(sessionTTL); err != nil {
+				l.Error(err)
+			}
+		}
+	}()
 	trigger := &nlp.StructuredInput{
 		Commands: []string{
 			"find",
@@ -76,7 +63,7 @@ func main() {
 		},
 		Objects: language.Foods(),
 	}
-	p, err = plugin.NewPlugin(pkgName, coreaddr, trigger)
+	p, err = plugin.NewPlugin(pluginName, coreaddr, trigger)
 	if err != nil {
 		l.Fatal("building", err)
 	}
@@ -86,19 +73,26 @@ func main() {
 	}
 }
 
+// Run translates the initial request into an Engine.Search call. It's a
+// thin adapter: all of the actual search/filter logic lives in Engine and
+// extractFilters so it can be tested without a live Abot core.
 func (t *Restaurant) Run(m *dt.Msg, resp *string) error {
 	m.State = map[string]interface{}{
 		"query":      "",
 		"location":   "",
 		"offset":     float64(0),
-		"businesses": []interface{}{},
-	}
-	si := m.StructuredInput
-	query := ""
-	for _, o := range si.Objects {
-		query += o + " "
-	}
+		"price":      "",
+		"categories": "",
+		"radius":     float64(0),
+		"open_now":   false,
+		"open_at":    float64(0),
+		"sort_by":    "",
+		"min_rating": float64(0),
+	}
+	remaining := extractFilters(m)
+	query := strings.Join(remaining, " ")
 	m.State["query"] = query
+
 	loc, question, err := knowledge.GetLocation(db, m.User)
 	if err != nil {
 		return err
@@ -127,27 +121,35 @@ func (t *Restaurant) Run(m *dt.Msg, resp *string) error {
 		}
 		m.State["location"] = loc.Name
 	}
-	if err := t.searchYelp(m, resp); err != nil {
-		return err
-	}
-	return nil
+	*resp, err = engine.Search(m.User.ID, searchParamsFromMsg(m, query), m.State["min_rating"].(float64))
+	return err
 }
 
-// FollowUp handles dialog question/answers and additional user queries
+// FollowUp handles dialog question/answers and additional user queries. It
+// translates *dt.Msg into Engine calls; the keyword dispatch itself lives
+// in Engine.HandleKeyword.
 func (t *Restaurant) FollowUp(m *dt.Msg, resp *string) error {
-	// First we handle dialog. If we asked for a location, use the response
+	// m.State is lost on a plugin restart or after a long enough cooldown
+	// that Abot didn't keep it in memory; rehydrate it from the user's
+	// persisted session so we don't crash below.
+	ensureState(m)
+
+	// First we handle dialog. If we asked for a location, use the response.
+	// The user's text is often ambiguous ("the airport", "downtown"), so
+	// resolve it against a bias circle around their known location before
+	// searching.
 	if m.State["location"] == "" {
-		// TODO smarter location detection, handling
-		m.State["location"] = m.Sentence
-		if err := t.searchYelp(m, resp); err != nil {
-			return err
-		}
-		return nil
+		loc := resolveLocation(m)
+		m.State["location"] = loc
+		params := searchParamsFromMsg(m, m.State["query"].(string))
+		var err error
+		*resp, err = engine.Search(m.User.ID, params, m.State["min_rating"].(float64))
+		return err
 	}
 
-	// If no businesses are returned inform the user now
-	if m.State["businesses"] != nil &&
-		len(m.State["businesses"].([]interface{})) == 0 {
+	// If the last search came back empty, say so now rather than matching
+	// a keyword against nothing.
+	if engine.NoResults(m.User.ID) {
 		*resp = "I couldn't find anything like that"
 		return nil
 	}
@@ -156,142 +158,78 @@ func (t *Restaurant) FollowUp(m *dt.Msg, resp *string) error {
 	// additional query. Handle the query by keyword
 	words := strings.Fields(*resp)
 	offI := int(m.State["offset"].(float64))
-	var s string
 	for _, w := range words {
 		w = strings.TrimRight(w, ").,;?!:")
+		if reply, ok, err := engine.HandleKeyword(m.User.ID, offI, w); ok {
+			if err != nil {
+				return err
+			}
+			*resp = reply
+			return nil
+		}
 		switch strings.ToLower(w) {
-		case "rated", "rating", "review", "recommend", "recommended":
-			s = fmt.Sprintf("It has a %s star review on Yelp",
-				getRating(m, offI))
-			*resp = s
-		case "number", "phone":
-			s = getPhone(m, offI)
-			*resp = s
-		case "call":
-			s = fmt.Sprintf("You can reach them here: %s",
-				getPhone(m, offI))
-			*resp = s
-		case "information", "info":
-			s = fmt.Sprintf("Here's some more info: %s",
-				getURL(m, offI))
-			*resp = s
-		case "where", "location", "address", "direction", "directions",
-			"addr":
-			s = fmt.Sprintf("It's at %s", getAddress(m, offI))
-			*resp = s
-		case "pictures", "pic", "pics":
-			s = fmt.Sprintf("I found some pics here: %s",
-				getURL(m, offI))
-			*resp = s
-		case "menu", "have":
-			s = fmt.Sprintf("Yelp might have a menu... %s",
-				getURL(m, offI))
-			*resp = s
 		case "not", "else", "no", "anything", "something":
+			params := searchParamsFromMsg(m, m.State["query"].(string))
+			params.Offset = offI
+			reply, err := engine.Next(m.User.ID, params, m.State["min_rating"].(float64))
+			if err != nil {
+				return err
+			}
 			m.State["offset"] = float64(offI + 1)
-			if err := t.searchYelp(m, resp); err != nil {
+			*resp = reply
+			return nil
+		case "cheaper", "closer":
+			params := searchParamsFromMsg(m, m.State["query"].(string))
+			reply, err := engine.Narrow(m.User.ID, params, m.State["min_rating"].(float64), w)
+			if err != nil {
 				return err
 			}
+			narrowParams(&params, w)
+			m.State["price"] = params.Price
+			m.State["radius"] = float64(params.Radius)
+			m.State["offset"] = float64(0)
+			*resp = reply
+			return nil
 		// TODO perhaps handle this case and "thanks" at the Abot level?
 		// with bayesian classification
 		case "good", "great", "yes", "perfect":
 			// TODO feed into learning engine
 			*resp = language.Positive()
+			return nil
 		case "thanks", "thank":
 			*resp = language.Welcome()
-		}
-		if len(*resp) > 0 {
 			return nil
 		}
 	}
 	return nil
 }
 
-func getRating(r *dt.Msg, offset int) string {
-	businesses := r.State["businesses"].([]interface{})
-	firstBusiness := businesses[offset].(map[string]interface{})
-	return fmt.Sprintf("%.1f", firstBusiness["Rating"].(float64))
-}
-
-func getURL(r *dt.Msg, offset int) string {
-	businesses := r.State["businesses"].([]interface{})
-	firstBusiness := businesses[offset].(map[string]interface{})
-	return firstBusiness["mobile_url"].(string)
-}
-
-func getPhone(r *dt.Msg, offset int) string {
-	businesses := r.State["businesses"].([]interface{})
-	firstBusiness := businesses[offset].(map[string]interface{})
-	return firstBusiness["display_phone"].(string)
-}
-
-func getAddress(r *dt.Msg, offset int) string {
-	businesses := r.State["businesses"].([]interface{})
-	firstBusiness := businesses[offset].(map[string]interface{})
-	location := firstBusiness["Location"].(map[string]interface{})
-	dispAddr := location["display_address"].([]interface{})
-	if len(dispAddr) > 1 {
-		str1 := dispAddr[0].(string)
-		str2 := dispAddr[1].(string)
-		return fmt.Sprintf("%s in %s", str1, str2)
+// resolveLocation normalizes the user's typed location before the first
+// search, using the active provider's autocomplete (when supported) biased
+// around any location Abot already knows for this user.
+func resolveLocation(m *dt.Msg) string {
+	var bias *provider.Coordinates
+	if loc, _, err := knowledge.GetLocation(db, m.User); err == nil && loc != nil {
+		bias = &provider.Coordinates{Latitude: loc.Lat, Longitude: loc.Lng}
 	}
-	return dispAddr[0].(string)
-}
-
-func (c *client) get(urlStr string, params url.Values, v interface{}) error {
-	resp, err := c.client.Get(nil, &c.token, urlStr, params)
+	resolved, err := engine.provider.AutocompleteLocation(m.Sentence, bias)
 	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-	if resp.StatusCode != 200 {
-		return fmt.Errorf("yelp status %d", resp.StatusCode)
+		return m.Sentence
 	}
-	return json.NewDecoder(resp.Body).Decode(v)
+	return resolved
 }
 
-func (t *Restaurant) searchYelp(m *dt.Msg, resp *string) error {
-	q := m.State["query"].(string)
-	loc := m.State["location"].(string)
-	offset := m.State["offset"].(float64)
-	l.Debugf("searching Yelp for %s at %s with offset %.0f", q, loc, offset)
-	form := url.Values{
-		"term":     {q},
-		"location": {loc},
-		"limit":    {fmt.Sprintf("%.0f", offset+1)},
+// searchParamsFromMsg builds a provider.SearchParams from the filters extractFilters
+// collected into m.State.
+func searchParamsFromMsg(m *dt.Msg, term string) provider.SearchParams {
+	return provider.SearchParams{
+		Term:       term,
+		Location:   m.State["location"].(string),
+		Price:      m.State["price"].(string),
+		Categories: m.State["categories"].(string),
+		Radius:     int(m.State["radius"].(float64)),
+		OpenNow:    m.State["open_now"].(bool),
+		OpenAt:     int64(m.State["open_at"].(float64)),
+		SortBy:     m.State["sort_by"].(string),
 	}
-	var data yelpResp
-	err := c.get("http://api.yelp.com/v2/search", form, &data)
-	if err != nil {
-		/*
-			m.Sentence = "I can't find that for you now. " +
-				"Let's try again later."
-			return err
-		*/
-		// return for confused response, given Yelp errors are rare, but
-		// unintentional runs of Yelp queries are much more common
-		return nil
-	}
-	m.State["businesses"] = data.Businesses
-	if len(data.Businesses) == 0 {
-		*resp = "I couldn't find any places like that nearby."
-		return nil
-	}
-	offI := int(offset)
-	if len(data.Businesses) <= offI {
-		*resp = "That's all I could find."
-		return nil
-	}
-	b := data.Businesses[offI]
-	addr := ""
-	if len(b.Location.DisplayAddress) > 0 {
-		addr = b.Location.DisplayAddress[0]
-	}
-	if offI == 0 {
-		*resp = "Ok. How does this place look? " + b.Name +
-			" at " + addr
-	} else {
-		*resp = fmt.Sprintf("What about %s instead?", b.Name)
-	}
-	return nil
 }
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	dt "github.com/itsabot/abot/shared/datatypes"
+	"github.com/jmoiron/sqlx"
+
+	"github.com/egtann/plugin_restaurants/provider"
+)
+
+// sessionTTL bounds how long a search session is kept around. Past this, a
+// user returning after a cooldown starts a fresh search rather than
+// resuming one that's no longer relevant.
+const sessionTTL = 2 * time.Hour
+
+// schema creates the tables this plugin needs. It's run once at startup;
+// every statement is idempotent so repeated runs across restarts are safe.
+const schema = `
+CREATE TABLE IF NOT EXISTS restaurant_sessions (
+	id bigserial PRIMARY KEY,
+	user_id bigint NOT NULL,
+	query text NOT NULL DEFAULT '',
+	location text NOT NULL DEFAULT '',
+	"offset" integer NOT NULL DEFAULT 0,
+	updated_at timestamptz NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS restaurant_sessions_user_id_updated_at_idx
+	ON restaurant_sessions (user_id, updated_at DESC);
+
+CREATE TABLE IF NOT EXISTS restaurant_businesses (
+	session_id bigint NOT NULL REFERENCES restaurant_sessions (id) ON DELETE CASCADE,
+	rank integer NOT NULL,
+	provider text NOT NULL,
+	external_id text NOT NULL,
+	payload jsonb NOT NULL,
+	PRIMARY KEY (session_id, rank)
+);
+`
+
+// session is the persisted search context for a user.
+type session struct {
+	ID       int64  `db:"id"`
+	UserID   uint64 `db:"user_id"`
+	Query    string `db:"query"`
+	Location string `db:"location"`
+	Offset   int    `db:"offset"`
+}
+
+// migrate creates the plugin's tables if they don't already exist.
+func migrate() error {
+	_, err := db.Exec(schema)
+	return err
+}
+
+// pgStore is the Postgres-backed SessionStore used in production. Tests use
+// a fake implementation instead so the Engine can run without a database.
+type pgStore struct {
+	db *sqlx.DB
+}
+
+func newPgStore(db *sqlx.DB) *pgStore {
+	return &pgStore{db: db}
+}
+
+// Save persists the current search context and its results so FollowUp can
+// resume it even after a plugin restart, rather than relying solely on the
+// in-memory m.State.
+func (s *pgStore) Save(userID uint64, query, location string, offset int, businesses []provider.Business) error {
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	var sessionID int64
+	row := tx.QueryRow(`
+		INSERT INTO restaurant_sessions (user_id, query, location, "offset", updated_at)
+		VALUES ($1, $2, $3, $4, now())
+		RETURNING id`, userID, query, location, offset)
+	if err := row.Scan(&sessionID); err != nil {
+		return err
+	}
+	for i, b := range businesses {
+		payload, err := json.Marshal(b)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`
+			INSERT INTO restaurant_businesses
+				(session_id, rank, provider, external_id, payload)
+			VALUES ($1, $2, $3, $4, $5)`,
+			sessionID, i, b.ProviderName, b.ExternalID, payload); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// Load returns the user's most recent search session and its businesses, in
+// rank order.
+func (s *pgStore) Load(userID uint64) (*session, []provider.Business, error) {
+	var sess session
+	err := s.db.Get(&sess, `
+		SELECT id, user_id, query, location, "offset"
+		FROM restaurant_sessions
+		WHERE user_id = $1
+		ORDER BY updated_at DESC
+		LIMIT 1`, userID)
+	if err == sql.ErrNoRows {
+		return nil, nil, ErrNoBusinesses
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	var payloads [][]byte
+	if err := s.db.Select(&payloads, `
+		SELECT payload FROM restaurant_businesses
+		WHERE session_id = $1
+		ORDER BY rank ASC`, sess.ID); err != nil {
+		return nil, nil, err
+	}
+	businesses := make([]provider.Business, len(payloads))
+	for i, pl := range payloads {
+		if err := json.Unmarshal(pl, &businesses[i]); err != nil {
+			return nil, nil, err
+		}
+	}
+	return &sess, businesses, nil
+}
+
+// Sweep deletes search sessions (and their businesses, via ON DELETE
+// CASCADE) older than ttl. Call this periodically from main so a
+// long-running plugin doesn't accumulate abandoned sessions forever.
+func (s *pgStore) Sweep(ttl time.Duration) error {
+	_, err := s.db.Exec(`
+		DELETE FROM restaurant_sessions
+		WHERE updated_at < now() - ($1 || ' seconds')::interval`,
+		ttl.Seconds())
+	return err
+}
+
+// ensureState rehydrates m.State from the user's persisted session when
+// it's missing, which happens after a plugin restart or a long enough
+// cooldown that Abot didn't carry it over in memory.
+func ensureState(m *dt.Msg) {
+	if m.State != nil {
+		return
+	}
+	m.State = map[string]interface{}{
+		"query":      "",
+		"location":   "",
+		"offset":     float64(0),
+		"price":      "",
+		"categories": "",
+		"radius":     float64(0),
+		"open_now":   false,
+		"open_at":    float64(0),
+		"sort_by":    "",
+		"min_rating": float64(0),
+	}
+	sess, _, err := engine.store.Load(m.User.ID)
+	if err != nil {
+		return
+	}
+	m.State["query"] = sess.Query
+	m.State["location"] = sess.Location
+	m.State["offset"] = float64(sess.Offset)
+}
@@ -0,0 +1,194 @@
+// Package yelp implements provider.Provider against the Yelp Fusion v3 API.
+package yelp
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/egtann/plugin_restaurants/provider"
+)
+
+const (
+	searchURL = "https://api.yelp.com/v3/businesses/search"
+	detailURL = "https://api.yelp.com/v3/businesses/"
+
+	// searchLimit is the page size requested on every search, independent
+	// of the caller's offset. Engine filters (e.g. min_rating) and then
+	// indexes into the result by offset, so the page has to be large
+	// enough to hold candidates beyond just offset+1, or a filter with
+	// few early matches looks like no results at all.
+	searchLimit = 20
+)
+
+// Provider talks to the Yelp Fusion v3 API. Fusion replaced the old v2 API
+// (and its OAuth 1.0a signing) with bearer auth, so there's no per-request
+// signing left to do. The app's API key itself is sent as the bearer token
+// on every request; no separate token exchange is made.
+type Provider struct {
+	apiKey string
+	http   *http.Client
+}
+
+// New builds a Yelp provider using the YELP_API_KEY environment variable.
+func New() *Provider {
+	return &Provider{
+		apiKey: os.Getenv("YELP_API_KEY"),
+		http:   &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (p *Provider) Name() string { return "yelp" }
+
+type searchResp struct {
+	Businesses []business `json:"businesses"`
+}
+
+type business struct {
+	ID           string  `json:"id"`
+	Name         string  `json:"name"`
+	ImageURL     string  `json:"image_url"`
+	URL          string  `json:"url"`
+	DisplayPhone string  `json:"display_phone"`
+	Distance     float64 `json:"distance"`
+	Rating       float64 `json:"rating"`
+	Price        string  `json:"price"`
+	ReviewCount  int     `json:"review_count"`
+	IsClosed     bool    `json:"is_closed"`
+	Categories   []struct {
+		Alias string `json:"alias"`
+		Title string `json:"title"`
+	} `json:"categories"`
+	Coordinates struct {
+		Latitude  float64 `json:"latitude"`
+		Longitude float64 `json:"longitude"`
+	} `json:"coordinates"`
+	Transactions []string `json:"transactions"`
+	Location     struct {
+		City           string   `json:"city"`
+		DisplayAddress []string `json:"display_address"`
+	} `json:"location"`
+}
+
+// detail is returned by the business detail endpoint, which carries fields
+// the search endpoint doesn't: hours, photos, and a menu URL when Yelp has
+// one on file.
+type detail struct {
+	business
+	Photos []string `json:"photos"`
+	Hours  []struct {
+		IsOpenNow bool `json:"is_open_now"`
+	} `json:"hours"`
+	MenuURL string `json:"menu_url"`
+}
+
+func (b business) normalize() provider.Business {
+	categories := make([]string, len(b.Categories))
+	for i, c := range b.Categories {
+		categories[i] = c.Alias
+	}
+	return provider.Business{
+		ProviderName: "yelp",
+		ExternalID:   b.ID,
+		Name:         b.Name,
+		ImageURL:     b.ImageURL,
+		URL:          b.URL,
+		Phone:        b.DisplayPhone,
+		Distance:     b.Distance,
+		Rating:       b.Rating,
+		Price:        b.Price,
+		ReviewCount:  b.ReviewCount,
+		IsClosed:     b.IsClosed,
+		Categories:   categories,
+		Coordinates: provider.Coordinates{
+			Latitude:  b.Coordinates.Latitude,
+			Longitude: b.Coordinates.Longitude,
+		},
+		City:           b.Location.City,
+		DisplayAddress: b.Location.DisplayAddress,
+	}
+}
+
+func (p *Provider) do(req *http.Request, v interface{}) error {
+	req.Header.Set("Authorization", "Bearer "+p.apiKey)
+	resp, err := p.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("yelp status %d", resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// Search queries the Fusion v3 search endpoint with the given filters.
+func (p *Provider) Search(sp provider.SearchParams) ([]provider.Business, error) {
+	form := url.Values{
+		"term":     {sp.Term},
+		"location": {sp.Location},
+		"limit":    {strconv.Itoa(searchLimit)},
+	}
+	if sp.Price != "" {
+		form.Set("price", sp.Price)
+	}
+	if sp.Categories != "" {
+		form.Set("categories", sp.Categories)
+	}
+	if sp.Radius > 0 {
+		form.Set("radius", strconv.Itoa(sp.Radius))
+	}
+	if sp.OpenNow {
+		form.Set("open_now", "true")
+	}
+	if sp.OpenAt > 0 {
+		form.Set("open_at", strconv.FormatInt(sp.OpenAt, 10))
+	}
+	if sp.SortBy != "" {
+		form.Set("sort_by", sp.SortBy)
+	}
+	req, err := http.NewRequest("GET", searchURL+"?"+form.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	var data searchResp
+	if err := p.do(req, &data); err != nil {
+		return nil, err
+	}
+	businesses := make([]provider.Business, len(data.Businesses))
+	for i, b := range data.Businesses {
+		businesses[i] = b.normalize()
+	}
+	return businesses, nil
+}
+
+// Details fetches the authoritative record for a single business, used by
+// FollowUp so hours, photos, and menu answers don't rely on stale data from
+// the search response.
+func (p *Provider) Details(externalID string) (*provider.Business, error) {
+	req, err := http.NewRequest("GET", detailURL+url.PathEscape(externalID), nil)
+	if err != nil {
+		return nil, err
+	}
+	var data detail
+	if err := p.do(req, &data); err != nil {
+		return nil, err
+	}
+	b := data.business.normalize()
+	b.MenuURL = data.MenuURL
+	b.Photos = data.Photos
+	if len(data.Hours) > 0 {
+		b.IsOpenNow = data.Hours[0].IsOpenNow
+	}
+	return &b, nil
+}
+
+// AutocompleteLocation is unimplemented: Yelp's Fusion API has no
+// location-autocomplete endpoint, only business/category autocomplete.
+func (p *Provider) AutocompleteLocation(input string, bias *provider.Coordinates) (string, error) {
+	return "", provider.ErrNotSupported
+}